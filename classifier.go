@@ -0,0 +1,173 @@
+// SPDX-FileCopyrightText: 2024 polykernel
+// SPDX-License-Identifier: MIT or Apache-2.0
+
+package caddyknownagents
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ClassificationsEndpoint is the address for the Known Agents agent
+// classification API endpoint, used to keep the local User-Agent ->
+// AgentType table in sync with the upstream catalog.
+const ClassificationsEndpoint = "https://api.knownagents.com/classifications"
+
+// knownAgentUserAgents seeds the classifier with the User-Agent substrings
+// Known Agents documents for its catalog, so responders and the knownagents
+// matcher work out of the box before the first classifications refresh.
+var knownAgentUserAgents = map[string]AgentType{
+	"GPTBot":              AIDataScraper,
+	"ChatGPT-User":        AIAssistant,
+	"OAI-SearchBot":       AISearchCrawler,
+	"ClaudeBot":           AIDataScraper,
+	"Claude-User":         AIAssistant,
+	"anthropic-ai":        AIAssistant,
+	"Amazonbot":           AIDataScraper,
+	"Bytespider":          AIDataScraper,
+	"CCBot":               AIDataScraper,
+	"Googlebot":           SearchEngineCrawlers,
+	"bingbot":             SearchEngineCrawlers,
+	"DuckDuckBot":         SearchEngineCrawlers,
+	"facebookexternalhit": Fetcher,
+	"AhrefsBot":           SEOCrawler,
+	"SemrushBot":          SEOCrawler,
+	"ia_archiver":         Archiver,
+	"archive.org_bot":     Archiver,
+	"HeadlessChrome":      HeadlessBrowser,
+	"PhantomJS":           HeadlessBrowser,
+}
+
+// classifier maps User-Agent strings to the AgentType Known Agents has
+// classified them as. It's safe for concurrent use: the underlying table is
+// swapped atomically so classify never blocks a refresh and vice versa.
+type classifier struct {
+	table atomic.Pointer[map[string]AgentType]
+}
+
+// newClassifier returns a classifier seeded with knownAgentUserAgents.
+func newClassifier() *classifier {
+	c := new(classifier)
+	seed := make(map[string]AgentType, len(knownAgentUserAgents))
+	for token, at := range knownAgentUserAgents {
+		seed[token] = at
+	}
+	c.table.Store(&seed)
+	return c
+}
+
+// sharedClassifierOnce and sharedClassifierInst back sharedClassifier.
+var (
+	sharedClassifierOnce sync.Once
+	sharedClassifierInst *classifier
+)
+
+// sharedClassifier returns the package-wide classifier instance. Both the
+// Knownagents handler and KnownagentsMatcher provision themselves with it,
+// so there's a single UA -> AgentType table and a background refresh
+// benefits both.
+func sharedClassifier() *classifier {
+	sharedClassifierOnce.Do(func() {
+		sharedClassifierInst = newClassifier()
+	})
+	return sharedClassifierInst
+}
+
+// classify returns the AgentType Known Agents has classified userAgent as,
+// matching on substring tokens, and whether a classification was found.
+func (c *classifier) classify(userAgent string) (AgentType, bool) {
+	if userAgent == "" {
+		return "", false
+	}
+	table := c.table.Load()
+	if table == nil {
+		return "", false
+	}
+	for token, at := range *table {
+		if strings.Contains(userAgent, token) {
+			return at, true
+		}
+	}
+	return "", false
+}
+
+// aiUserAgentTokens are substrings commonly present in the User-Agent
+// strings of AI-related agents that haven't yet been catalogued by Known
+// Agents.
+var aiUserAgentTokens = []string{"GPT", "Claude", "Gemini", "Llama", "-AI", "AI-", "bot", "Bot", "crawler", "Crawler", "spider", "Spider"}
+
+// standardBrowserTokens are substrings present in the User-Agent strings of
+// ordinary browsers, used to avoid misclassifying them as undocumented AI
+// agents.
+var standardBrowserTokens = []string{"Mozilla/", "AppleWebKit/", "Gecko/", "Chrome/", "Safari/", "Firefox/", "Edg/"}
+
+// classifyWithHeuristics is like classify, but when userAgent isn't in the
+// known table it falls back to substring heuristics (AI/bot-like tokens and
+// the absence of standard browser tokens) so agents Known Agents hasn't
+// catalogued yet still classify as UndocumentedAIAgent where useful.
+func (c *classifier) classifyWithHeuristics(userAgent string) (AgentType, bool) {
+	if at, ok := c.classify(userAgent); ok {
+		return at, ok
+	}
+	return inferUnknownAIAgent(userAgent)
+}
+
+// inferUnknownAIAgent heuristically classifies userAgent as
+// UndocumentedAIAgent based on common AI/bot substrings, as long as none of
+// the standard browser tokens are present.
+func inferUnknownAIAgent(userAgent string) (AgentType, bool) {
+	if userAgent == "" {
+		return "", false
+	}
+	for _, token := range standardBrowserTokens {
+		if strings.Contains(userAgent, token) {
+			return "", false
+		}
+	}
+	for _, token := range aiUserAgentTokens {
+		if strings.Contains(userAgent, token) {
+			return UndocumentedAIAgent, true
+		}
+	}
+	return "", false
+}
+
+// refresh re-fetches the User-Agent -> AgentType table from
+// ClassificationsEndpoint and swaps it in atomically.
+func (c *classifier) refresh(accessToken string, logger *zap.Logger) error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	req, err := http.NewRequest("GET", ClassificationsEndpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d fetching classifications", resp.StatusCode)
+	}
+
+	var table map[string]AgentType
+	if err := json.NewDecoder(resp.Body).Decode(&table); err != nil {
+		return err
+	}
+
+	c.table.Store(&table)
+	logger.Info("Refreshed agent classifications", zap.Int("count", len(table)))
+
+	return nil
+}
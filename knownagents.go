@@ -5,15 +5,26 @@ package caddyknownagents
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
+	"os"
+	"os/signal"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
@@ -26,6 +37,48 @@ const AnalyticsEndpoint = "https://api.knownagents.com/visits"
 // The address for the Known Agents robots.txt generation API endpoint.
 const RobotsTxtEndpoint = "https://api.knownagents.com/robots-txts"
 
+// defaultRobotsTxtServeAt is the request path at which the generated
+// robots.txt is served when RobotsTxt.ServeAt is left unset.
+const defaultRobotsTxtServeAt = "/robots.txt"
+
+// defaultRefreshInterval is how often robots.txt is re-fetched in the
+// background when RobotsTxt.RefreshInterval is left unset.
+const defaultRefreshInterval = 12 * time.Hour
+
+// refreshJitterFraction bounds the random jitter applied to each refresh
+// interval (as a fraction of the interval) to avoid thundering herds.
+const refreshJitterFraction = 0.10
+
+// minRetryBackoff and maxRetryBackoff bound the exponential backoff applied
+// between retries after a failed background refresh.
+const (
+	minRetryBackoff = 30 * time.Second
+	maxRetryBackoff = 30 * time.Minute
+)
+
+// Defaults for the analyticsQueue, used when the corresponding Knownagents
+// field is left unset.
+const (
+	defaultQueueSize     = 1024
+	defaultBatchSize     = 50
+	defaultFlushInterval = 5 * time.Second
+)
+
+// analyticsWorkerCount is the number of goroutines draining the analytics
+// queue and posting batches upstream.
+const analyticsWorkerCount = 4
+
+// analyticsRequestTimeout bounds each batched upstream request.
+const analyticsRequestTimeout = 10 * time.Second
+
+// analyticsShutdownGrace bounds how long Cleanup waits for the analytics
+// queue to flush its remaining events.
+const analyticsShutdownGrace = 5 * time.Second
+
+// analyticsDropLogEvery throttles the "queue full" warning to once per this
+// many dropped events.
+const analyticsDropLogEvery = 100
+
 // AgentTypes are groups of agent classified by the Known Agents API.
 type AgentType = string
 
@@ -81,7 +134,25 @@ type Knownagents struct {
 	// the Known Agents robots.txt generation API endpoint.
 	RobotsTxt *RobotsTxt `json:"robots_txt,omitempty"`
 
-	logger *zap.Logger
+	// Bounds the in-memory queue of pending visit events. Defaults to 1024.
+	QueueSize int `json:"queue_size,omitempty"`
+
+	// The number of visit events batched per upstream request. Defaults to 50.
+	BatchSize int `json:"batch_size,omitempty"`
+
+	// How long to wait before flushing a partial batch of visit events.
+	// Defaults to 5s.
+	FlushInterval caddy.Duration `json:"flush_interval,omitempty"`
+
+	// Pluggable sub-modules that react locally to classified requests, e.g.
+	// by blocking, tarpitting, redirecting, or serving alternate content.
+	// Keyed by a name under which each is configured in the Caddyfile.
+	RespondersRaw caddy.ModuleMap `json:"responders,omitempty" caddy:"namespace=http.knownagents.responders"`
+
+	logger     *zap.Logger
+	analytics  *analyticsQueue
+	classifier *classifier
+	responders map[AgentType]Responder
 }
 
 // RobotsTxt configures automated generation of robots.txt via the Known Agents API.
@@ -92,7 +163,235 @@ type RobotsTxt struct {
 	// The path to disallow access for the specified agent types.
 	Disallow string `json:"disallow,omitempty"`
 
-	text string `json:"-"`
+	// The request path at which the generated robots.txt is served directly
+	// by the middleware. Defaults to "/robots.txt".
+	ServeAt string `json:"serve_at,omitempty"`
+
+	// The body served at ServeAt when no robots.txt has been fetched
+	// successfully yet, so that sites don't 500 on first boot.
+	FallbackBody string `json:"fallback_body,omitempty"`
+
+	// How often robots.txt is re-fetched in the background. Defaults to 12h.
+	RefreshInterval caddy.Duration `json:"refresh_interval,omitempty"`
+
+	// When set, the background refresher also listens for SIGHUP and
+	// triggers an out-of-band refresh when one is received.
+	ForceRefreshOnSighup bool `json:"force_refresh_on_sighup,omitempty"`
+
+	state atomic.Pointer[robotsTxtState] `json:"-"`
+
+	refreshDone    chan struct{} `json:"-"`
+	refreshTrigger chan struct{} `json:"-"`
+}
+
+// robotsTxtQuery is the payload sent to RobotsTxtEndpoint. It's deliberately
+// narrower than RobotsTxt: local serving and refresh configuration has no
+// bearing on what Known Agents should generate, and shouldn't be leaked to
+// the upstream API.
+type robotsTxtQuery struct {
+	AgentTypes []AgentType `json:"agent_types"`
+	Disallow   string      `json:"disallow,omitempty"`
+}
+
+// robotsTxtState is an immutable snapshot of the most recently fetched
+// robots.txt. It's swapped atomically so ServeHTTP can read it concurrently
+// with the background refresher.
+type robotsTxtState struct {
+	text        string
+	etag        string
+	lastFetched time.Time
+}
+
+// analyticsQueue buffers visit events in a bounded channel and drains them
+// with a small pool of workers that batch posts to the Known Agents
+// analytics API, so ServeHTTP never blocks on or dials out for a single
+// request.
+type analyticsQueue struct {
+	ch     chan map[string]interface{}
+	client *http.Client
+	logger *zap.Logger
+
+	accessToken   string
+	batchSize     int
+	flushInterval time.Duration
+
+	dropped atomic.Uint64
+
+	wg   sync.WaitGroup
+	done chan struct{}
+}
+
+// newAnalyticsQueue builds an analyticsQueue ready to have its workers
+// started with start.
+func newAnalyticsQueue(accessToken string, queueSize, batchSize int, flushInterval time.Duration, logger *zap.Logger) *analyticsQueue {
+	return &analyticsQueue{
+		ch: make(chan map[string]interface{}, queueSize),
+		client: &http.Client{
+			Transport: &http.Transport{
+				MaxIdleConns:        analyticsWorkerCount * 2,
+				MaxIdleConnsPerHost: analyticsWorkerCount * 2,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+		logger:        logger,
+		accessToken:   accessToken,
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		done:          make(chan struct{}),
+	}
+}
+
+// start launches the given number of worker goroutines draining the queue.
+func (q *analyticsQueue) start(workers int) {
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+}
+
+// enqueue queues ev for delivery without blocking. If the queue is full, the
+// oldest pending event is dropped to make room and a throttled warning is
+// logged.
+func (q *analyticsQueue) enqueue(ev map[string]interface{}) {
+	select {
+	case q.ch <- ev:
+		return
+	default:
+	}
+
+	select {
+	case <-q.ch:
+		q.recordDrop()
+	default:
+	}
+
+	select {
+	case q.ch <- ev:
+	default:
+		q.recordDrop()
+	}
+}
+
+func (q *analyticsQueue) recordDrop() {
+	dropped := q.dropped.Add(1)
+	if dropped%analyticsDropLogEvery == 1 {
+		q.logger.Warn("Analytics queue full, dropping oldest visit events", zap.Uint64("dropped_total", dropped))
+	}
+}
+
+// worker drains the queue, batching events by size or by flushInterval,
+// whichever comes first, until stop closes q.done.
+func (q *analyticsQueue) worker() {
+	defer q.wg.Done()
+
+	batch := make([]map[string]interface{}, 0, q.batchSize)
+	timer := time.NewTimer(q.flushInterval)
+	defer timer.Stop()
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		q.send(batch)
+		batch = batch[:0]
+	}
+
+	resetTimer := func() {
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(q.flushInterval)
+	}
+
+	for {
+		select {
+		case ev := <-q.ch:
+			batch = append(batch, ev)
+			if len(batch) >= q.batchSize {
+				flush()
+				resetTimer()
+			}
+
+		case <-timer.C:
+			flush()
+			timer.Reset(q.flushInterval)
+
+		case <-q.done:
+			q.drain(&batch, flush)
+			return
+		}
+	}
+}
+
+// drain flushes whatever is already buffered in the queue, without blocking,
+// so shutdown doesn't silently discard events that are ready to send.
+func (q *analyticsQueue) drain(batch *[]map[string]interface{}, flush func()) {
+	for {
+		select {
+		case ev := <-q.ch:
+			*batch = append(*batch, ev)
+			if len(*batch) >= q.batchSize {
+				flush()
+			}
+		default:
+			flush()
+			return
+		}
+	}
+}
+
+// send marshals batch as a JSON array and posts it to AnalyticsEndpoint.
+func (q *analyticsQueue) send(batch []map[string]interface{}) {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		q.logger.Error("Error marshaling visit event batch", zap.Error(err))
+		return
+	}
+
+	q.logger.Debug("Visit event batch payload constructed", zap.Int("count", len(batch)), zap.ByteString("payload", body))
+
+	ctx, cancel := context.WithTimeout(context.Background(), analyticsRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", AnalyticsEndpoint, bytes.NewReader(body))
+	if err != nil {
+		q.logger.Error("Error creating request", zap.Error(err))
+		return
+	}
+
+	req.Header.Set("Authorization", "Bearer "+q.accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := q.client.Do(req)
+	if err != nil {
+		q.logger.Warn("Error sending visit event batch", zap.Error(err))
+		return
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	q.logger.Debug("Visit event batch sent", zap.Int("status", resp.StatusCode), zap.Int("count", len(batch)))
+}
+
+// stop signals all workers to flush and exit, waiting up to grace for them
+// to finish before giving up.
+func (q *analyticsQueue) stop(grace time.Duration) {
+	close(q.done)
+
+	stopped := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(grace):
+		q.logger.Warn("Analytics queue did not flush all events before shutdown grace period elapsed")
+	}
 }
 
 // CaddyModule returns the Caddy module information.
@@ -108,7 +407,10 @@ func (Knownagents) CaddyModule() caddy.ModuleInfo {
 func (m *Knownagents) FetchRobotsTxt(ctx caddy.Context) error {
 	m.logger.Info("Fetching generated robots.txt")
 
-	query, err := json.Marshal(m.RobotsTxt)
+	query, err := json.Marshal(robotsTxtQuery{
+		AgentTypes: m.RobotsTxt.AgentTypes,
+		Disallow:   m.RobotsTxt.Disallow,
+	})
 	if err != nil {
 		m.logger.Error("Error marshaling robots.txt query", zap.Error(err))
 		return err
@@ -141,11 +443,76 @@ func (m *Knownagents) FetchRobotsTxt(ctx caddy.Context) error {
 		m.logger.Warn("Error reading response body", zap.Error(err))
 		return err
 	}
-	m.RobotsTxt.text = string(body)
+
+	if resp.StatusCode != http.StatusOK {
+		statusErr := fmt.Errorf("unexpected status %d fetching robots.txt", resp.StatusCode)
+		if isRetryableStatus(resp.StatusCode) {
+			return &fetchError{err: statusErr, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+		}
+		return statusErr
+	}
+
+	m.RobotsTxt.state.Store(&robotsTxtState{
+		text:        string(body),
+		etag:        computeETag(body),
+		lastFetched: time.Now(),
+	})
 
 	return nil
 }
 
+// computeETag derives a weak content hash suitable for use as an ETag from a
+// fetched robots.txt body.
+func computeETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return fmt.Sprintf(`"%x"`, sum[:8])
+}
+
+// fetchError wraps a robots.txt fetch failure with an optional
+// server-suggested retry delay, parsed from a Retry-After header.
+type fetchError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *fetchError) Error() string { return e.err.Error() }
+func (e *fetchError) Unwrap() error { return e.err }
+
+// isRetryableStatus reports whether status is a transient failure (rate
+// limiting or a server error) worth retrying in the background.
+func isRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// parseRetryAfter parses a Retry-After header value, which may be either
+// delay-seconds or an HTTP-date, returning 0 if it's absent or unparsable.
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(header); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if when, err := http.ParseTime(header); err == nil {
+		if d := time.Until(when); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// jitter returns d adjusted by a random offset of up to ±fraction of d.
+func jitter(d time.Duration, fraction float64) time.Duration {
+	if d <= 0 {
+		return d
+	}
+	delta := time.Duration(float64(d) * fraction)
+	if delta <= 0 {
+		return d
+	}
+	return d + time.Duration(rand.Int63n(int64(2*delta))) - delta
+}
+
 // Provision implements caddy.Provisioner.
 func (m *Knownagents) Provision(ctx caddy.Context) error {
 	repl := caddy.NewReplacer()
@@ -154,19 +521,181 @@ func (m *Knownagents) Provision(ctx caddy.Context) error {
 
 	m.logger = ctx.Logger()
 
+	if m.QueueSize < 0 {
+		return fmt.Errorf("queue_size must not be negative, got %d", m.QueueSize)
+	}
+	if m.BatchSize < 0 {
+		return fmt.Errorf("batch_size must not be negative, got %d", m.BatchSize)
+	}
+	if m.QueueSize == 0 {
+		m.QueueSize = defaultQueueSize
+	}
+	if m.BatchSize == 0 {
+		m.BatchSize = defaultBatchSize
+	}
+	if m.FlushInterval == 0 {
+		m.FlushInterval = caddy.Duration(defaultFlushInterval)
+	}
+	m.analytics = newAnalyticsQueue(m.AccessToken, m.QueueSize, m.BatchSize, time.Duration(m.FlushInterval), m.logger)
+	m.analytics.start(analyticsWorkerCount)
+
 	if m.RobotsTxt != nil {
 		if m.RobotsTxt.Disallow == "" {
 			m.RobotsTxt.Disallow = "/"
 		}
-		err := m.FetchRobotsTxt(ctx)
+		if m.RobotsTxt.ServeAt == "" {
+			m.RobotsTxt.ServeAt = defaultRobotsTxtServeAt
+		}
+		if m.RobotsTxt.RefreshInterval == 0 {
+			m.RobotsTxt.RefreshInterval = caddy.Duration(defaultRefreshInterval)
+		}
+
+		m.RobotsTxt.refreshDone = make(chan struct{})
+		m.RobotsTxt.refreshTrigger = make(chan struct{}, 1)
+
+		if err := m.FetchRobotsTxt(ctx); err != nil {
+			m.logger.Warn("Initial robots.txt fetch failed, will retry in the background", zap.Error(err))
+		}
+
+		go m.refreshRobotsTxtLoop(ctx)
+
+		if m.RobotsTxt.ForceRefreshOnSighup {
+			go m.watchSighup()
+		}
+	}
+
+	m.classifier = sharedClassifier()
+
+	if len(m.RespondersRaw) > 0 {
+		mods, err := ctx.LoadModule(m, "RespondersRaw")
 		if err != nil {
-			return err
+			return fmt.Errorf("loading responder modules: %v", err)
+		}
+
+		m.responders = make(map[AgentType]Responder)
+		for name, modIface := range mods.(map[string]any) {
+			responder, ok := modIface.(classifiedResponder)
+			if !ok {
+				return fmt.Errorf("module for responder '%s' does not implement Responder", name)
+			}
+			for _, at := range responder.configuredAgentTypes() {
+				m.responders[at] = responder
+			}
 		}
 	}
 
 	return nil
 }
 
+// Cleanup implements caddy.CleanerUpper. It stops the background robots.txt
+// refresher started in Provision and flushes any queued analytics events.
+func (m *Knownagents) Cleanup() error {
+	if m.RobotsTxt != nil && m.RobotsTxt.refreshDone != nil {
+		close(m.RobotsTxt.refreshDone)
+	}
+	if m.analytics != nil {
+		m.analytics.stop(analyticsShutdownGrace)
+	}
+	return nil
+}
+
+// refreshRobotsTxtLoop periodically re-fetches robots.txt on
+// RefreshInterval (plus jitter) until Cleanup is called, retrying failed
+// fetches immediately with capped exponential backoff rather than waiting
+// for the next scheduled refresh.
+func (m *Knownagents) refreshRobotsTxtLoop(ctx caddy.Context) {
+	rt := m.RobotsTxt
+
+	for {
+		wait := jitter(time.Duration(rt.RefreshInterval), refreshJitterFraction)
+
+		select {
+		case <-time.After(wait):
+		case <-rt.refreshTrigger:
+		case <-rt.refreshDone:
+			return
+		}
+
+		ok := retryWithBackoff(
+			func() error { return m.FetchRobotsTxt(ctx) },
+			rt.refreshDone,
+			minRetryBackoff,
+			maxRetryBackoff,
+			func(err error, retryAfter time.Duration) {
+				m.logger.Warn("Failed to refresh robots.txt, retrying", zap.Error(err), zap.Duration("retry_after", retryAfter))
+			},
+		)
+		if !ok {
+			return
+		}
+
+		m.logger.Info("Refreshed robots.txt")
+		if refreshErr := m.classifier.refresh(m.AccessToken, m.logger); refreshErr != nil {
+			m.logger.Warn("Failed to refresh agent classifications", zap.Error(refreshErr))
+		}
+	}
+}
+
+// retryWithBackoff calls fetch until it succeeds, retrying failures
+// immediately after a capped exponential backoff (seeded from minBackoff,
+// doubling up to maxBackoff, and overridden by any Retry-After a
+// *fetchError carries) rather than deferring to the caller's own schedule.
+// It reports whether fetch succeeded, or false if done fired first. onRetry,
+// if non-nil, is called before each wait with the failure and the delay
+// chosen for it.
+func retryWithBackoff(fetch func() error, done <-chan struct{}, minBackoff, maxBackoff time.Duration, onRetry func(err error, retryAfter time.Duration)) bool {
+	backoff := minBackoff
+
+	for {
+		err := fetch()
+		if err == nil {
+			return true
+		}
+
+		retryAfter := backoff
+		var fe *fetchError
+		if errors.As(err, &fe) && fe.retryAfter > 0 {
+			retryAfter = fe.retryAfter
+		}
+		if onRetry != nil {
+			onRetry(err, retryAfter)
+		}
+
+		select {
+		case <-time.After(retryAfter):
+		case <-done:
+			return false
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// watchSighup triggers an out-of-band robots.txt refresh whenever the
+// process receives SIGHUP, for operators who'd rather signal than wait for
+// the next scheduled refresh.
+func (m *Knownagents) watchSighup() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	defer signal.Stop(sigCh)
+
+	rt := m.RobotsTxt
+	for {
+		select {
+		case <-sigCh:
+			select {
+			case rt.refreshTrigger <- struct{}{}:
+			default:
+			}
+		case <-rt.refreshDone:
+			return
+		}
+	}
+}
+
 // Validate implements caddy.Validator.
 func (m Knownagents) Validate() error {
 	m.logger.Debug("Access Token: " + m.AccessToken)
@@ -195,7 +724,29 @@ func (m Knownagents) ServeHTTP(
 	next caddyhttp.Handler,
 ) error {
 	if m.RobotsTxt != nil {
-		caddyhttp.SetVar(r.Context(), "ka_robots_txt", m.RobotsTxt.text)
+		state := m.RobotsTxt.state.Load()
+
+		var text string
+		if state != nil {
+			text = state.text
+		}
+		caddyhttp.SetVar(r.Context(), "ka_robots_txt", text)
+
+		if (r.Method == http.MethodGet || r.Method == http.MethodHead) && r.URL.Path == m.RobotsTxt.ServeAt {
+			return m.serveRobotsTxt(w, r, state)
+		}
+	}
+
+	if m.responders != nil {
+		if classification, ok := m.classifier.classifyWithHeuristics(r.UserAgent()); ok {
+			if responder, ok := m.responders[classification]; ok {
+				handled, err := responder.Respond(w, r, classification)
+				if handled {
+					m.recordVisit(r)
+					return err
+				}
+			}
+		}
 	}
 
 	// run the next handler
@@ -204,54 +755,107 @@ func (m Knownagents) ServeHTTP(
 		return err
 	}
 
-	go func() {
-		sanitizedHeaders := r.Header.Clone()
-		sanitizedHeaders.Del("Cookie")
+	m.recordVisit(r)
 
-		visitEvent := map[string]interface{}{
-			"request_path":    r.URL.Path,
-			"request_method":  r.Method,
-			"request_headers": sanitizedHeaders,
-		}
+	return nil
+}
 
-		body, err := json.Marshal(visitEvent)
-		if err != nil {
-			m.logger.Error("Error marshaling visitor event", zap.Error(err))
-			return
-		}
+// recordVisit enqueues a sanitized visit event for asynchronous delivery to
+// the Known Agents analytics API.
+func (m Knownagents) recordVisit(r *http.Request) {
+	sanitizedHeaders := r.Header.Clone()
+	sanitizedHeaders.Del("Cookie")
+
+	m.analytics.enqueue(map[string]interface{}{
+		"request_path":    r.URL.Path,
+		"request_method":  r.Method,
+		"request_headers": sanitizedHeaders,
+	})
+}
 
-		m.logger.Debug("Visit event payload constructed", zap.ByteString("payload", body))
+// serveRobotsTxt writes the cached robots.txt (or RobotsTxt.FallbackBody if
+// no fetch has succeeded yet), handles conditional requests, and
+// short-circuits the handler chain.
+func (m Knownagents) serveRobotsTxt(w http.ResponseWriter, r *http.Request, state *robotsTxtState) error {
+	rt := m.RobotsTxt
 
-		client := &http.Client{}
-		req, err := http.NewRequest("POST", AnalyticsEndpoint, bytes.NewBuffer(body))
-		if err != nil {
-			m.logger.Error("Error creating request", zap.Error(err))
-			return
-		}
+	var body, etag string
+	var lastFetched time.Time
+	if state != nil {
+		body, etag, lastFetched = state.text, state.etag, state.lastFetched
+	}
+	if body == "" {
+		body = rt.FallbackBody
+	}
 
-		req.Header.Set("Authorization", "Bearer "+m.AccessToken)
-		req.Header.Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
 
-		resp, err := client.Do(req)
-		if err != nil {
-			m.logger.Warn("Error sending visitor event", zap.Error(err))
-		} else {
-			m.logger.Debug("Visitor event sent", zap.Int("status", resp.StatusCode))
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if !lastFetched.IsZero() {
+		w.Header().Set("Last-Modified", lastFetched.UTC().Format(http.TimeFormat))
+	}
+
+	if etag != "" && etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	if !lastFetched.IsZero() {
+		if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil {
+			if !lastFetched.Truncate(time.Second).After(since) {
+				w.WriteHeader(http.StatusNotModified)
+				return nil
+			}
 		}
-		defer func() {
-			_ = resp.Body.Close()
-		}()
-	}()
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		_, _ = io.WriteString(w, body)
+	}
 
 	return nil
 }
 
+// etagMatches reports whether ifNoneMatch (the raw If-None-Match header
+// value, possibly a comma-separated list) matches etag.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		candidate = strings.TrimPrefix(candidate, "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
 // UnmarshalCaddyfile implements caddyfile.Unmarshaler.
 func (m *Knownagents) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 	d.Next() // consume directive name
 
 	for nesting := d.Nesting(); d.NextBlock(nesting); {
 		switch d.Val() {
+		case "responders":
+			if m.RespondersRaw != nil {
+				return d.Err("responders is already configured")
+			}
+			m.RespondersRaw = make(caddy.ModuleMap)
+			for nesting := d.Nesting(); d.NextBlock(nesting); {
+				name := d.Val()
+				modID := "http.knownagents.responders." + name
+				unm, err := caddyfile.UnmarshalModule(d, modID)
+				if err != nil {
+					return err
+				}
+				m.RespondersRaw[name] = caddyconfig.JSON(unm, nil)
+			}
+
 		case "robots_txt":
 			if m.RobotsTxt != nil {
 				return d.Err("robots_txt is already configured")
@@ -280,6 +884,31 @@ func (m *Knownagents) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 						return d.ArgErr()
 					}
 					m.RobotsTxt.Disallow = d.Val()
+
+				case "serve_at":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					m.RobotsTxt.ServeAt = d.Val()
+
+				case "fallback_body":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					m.RobotsTxt.FallbackBody = d.Val()
+
+				case "refresh_interval":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					interval, err := caddy.ParseDuration(d.Val())
+					if err != nil {
+						return d.Errf("parsing refresh_interval duration: %v", err)
+					}
+					m.RobotsTxt.RefreshInterval = caddy.Duration(interval)
+
+				case "force_refresh_on_sighup":
+					m.RobotsTxt.ForceRefreshOnSighup = true
 				default:
 					return d.Errf("unknown subdirective '%s'", d.Val())
 				}
@@ -289,6 +918,36 @@ func (m *Knownagents) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 				return d.ArgErr()
 			}
 			m.AccessToken = d.Val()
+
+		case "queue_size":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			size, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return d.Errf("parsing queue_size: %v", err)
+			}
+			m.QueueSize = size
+
+		case "batch_size":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			size, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return d.Errf("parsing batch_size: %v", err)
+			}
+			m.BatchSize = size
+
+		case "flush_interval":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			interval, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("parsing flush_interval duration: %v", err)
+			}
+			m.FlushInterval = caddy.Duration(interval)
 		default:
 			return d.Errf("unrecognized subdirective '%s'", d.Val())
 		}
@@ -330,6 +989,7 @@ func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error)
 // Interface guards
 var (
 	_ caddy.Provisioner           = (*Knownagents)(nil)
+	_ caddy.CleanerUpper          = (*Knownagents)(nil)
 	_ caddy.Validator             = (*Knownagents)(nil)
 	_ caddyhttp.MiddlewareHandler = (*Knownagents)(nil)
 	_ caddyfile.Unmarshaler       = (*Knownagents)(nil)
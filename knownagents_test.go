@@ -4,10 +4,14 @@
 package caddyknownagents
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 )
@@ -99,6 +103,107 @@ func TestUnmarshalModuleRobotsTxt(t *testing.T) {
 	}
 }
 
+func TestServeRobotsTxt(t *testing.T) {
+	fmt.Println("Testing serveRobotsTxt... ")
+
+	fallback := "User-agent: *\nDisallow: /\n"
+	m := Knownagents{
+		RobotsTxt: &RobotsTxt{FallbackBody: fallback},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/robots.txt", nil)
+	rec := httptest.NewRecorder()
+	if err := m.serveRobotsTxt(rec, req, nil); err != nil {
+		t.Errorf("serveRobotsTxt failed with %v", err)
+		return
+	}
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status %d but got %d", http.StatusOK, rec.Code)
+		return
+	}
+	if rec.Body.String() != fallback {
+		t.Errorf("Expected fallback body %q but got %q", fallback, rec.Body.String())
+		return
+	}
+
+	state := &robotsTxtState{
+		text:        "User-agent: *\nDisallow: /private\n",
+		etag:        computeETag([]byte("User-agent: *\nDisallow: /private\n")),
+		lastFetched: time.Now(),
+	}
+
+	rec = httptest.NewRecorder()
+	if err := m.serveRobotsTxt(rec, req, state); err != nil {
+		t.Errorf("serveRobotsTxt failed with %v", err)
+		return
+	}
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status %d but got %d", http.StatusOK, rec.Code)
+		return
+	}
+	if rec.Body.String() != state.text {
+		t.Errorf("Expected body %q but got %q", state.text, rec.Body.String())
+		return
+	}
+	if got := rec.Header().Get("ETag"); got != state.etag {
+		t.Errorf("Expected ETag %q but got %q", state.etag, got)
+		return
+	}
+
+	conditional := httptest.NewRequest(http.MethodGet, "/robots.txt", nil)
+	conditional.Header.Set("If-None-Match", state.etag)
+	rec = httptest.NewRecorder()
+	if err := m.serveRobotsTxt(rec, conditional, state); err != nil {
+		t.Errorf("serveRobotsTxt failed with %v", err)
+		return
+	}
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("Expected status %d but got %d", http.StatusNotModified, rec.Code)
+		return
+	}
+}
+
+func TestRetryWithBackoffRetriesImmediatelyOnFailure(t *testing.T) {
+	fmt.Println("Testing retryWithBackoff retries without waiting for the caller's schedule... ")
+
+	var gaps []time.Duration
+	last := time.Now()
+	attempts := 0
+	fetch := func() error {
+		now := time.Now()
+		gaps = append(gaps, now.Sub(last))
+		last = now
+		attempts++
+		if attempts < 3 {
+			return errors.New("boom")
+		}
+		return nil
+	}
+
+	done := make(chan struct{})
+	ok := retryWithBackoff(fetch, done, 20*time.Millisecond, 200*time.Millisecond, nil)
+	if !ok {
+		t.Error("Expected retryWithBackoff to report success")
+		return
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 fetch attempts (2 failures then a success), got %d", attempts)
+		return
+	}
+
+	// Two consecutive failures: the retry after each should be bounded by
+	// the (doubling) backoff, not by whatever schedule the caller waits on
+	// between successful refreshes (which can be hours).
+	if gaps[1] < 15*time.Millisecond || gaps[1] > 100*time.Millisecond {
+		t.Errorf("Expected the first retry after ~20ms, got %s", gaps[1])
+		return
+	}
+	if gaps[2] < 30*time.Millisecond || gaps[2] > 200*time.Millisecond {
+		t.Errorf("Expected the second retry after ~40ms, got %s", gaps[2])
+		return
+	}
+}
+
 func TestUnmarshalModuleRobotsTxtWildcard(t *testing.T) {
 	fmt.Println("Testing unmarshal module (robots.txt block + wildcard)... ")
 	access_token := "aHVudGVyMg=="
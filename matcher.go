@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: 2024 polykernel
+// SPDX-License-Identifier: MIT or Apache-2.0
+
+package caddyknownagents
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(KnownagentsMatcher{})
+}
+
+// KnownagentsMatcher matches requests whose User-Agent classifies as one of
+// the configured agent types, using the same classifier the responders
+// subsystem uses.
+//
+// Syntax:
+//
+//	@name knownagents <agent_types...>
+type KnownagentsMatcher struct {
+	// The agent types to match against. Supports the `*` wildcard,
+	// consistent with robots_txt's agent_types.
+	AgentTypes []AgentType `json:"agent_types,omitempty"`
+
+	classifier *classifier
+}
+
+// CaddyModule returns the Caddy module information.
+func (KnownagentsMatcher) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.matchers.knownagents",
+		New: func() caddy.Module { return new(KnownagentsMatcher) },
+	}
+}
+
+// Provision implements caddy.Provisioner.
+func (m *KnownagentsMatcher) Provision(_ caddy.Context) error {
+	m.classifier = sharedClassifier()
+	return nil
+}
+
+// Validate implements caddy.Validator.
+func (m KnownagentsMatcher) Validate() error {
+	for _, at := range m.AgentTypes {
+		if !slices.Contains(allAgentTypes, at) {
+			return fmt.Errorf("unrecognized agent type '%s'", at)
+		}
+	}
+	return nil
+}
+
+// Match implements caddyhttp.RequestMatcher.
+func (m KnownagentsMatcher) Match(r *http.Request) bool {
+	classification, ok := m.classifier.classifyWithHeuristics(r.UserAgent())
+	if !ok {
+		return false
+	}
+	return slices.Contains(m.AgentTypes, classification)
+}
+
+// UnmarshalCaddyfile implements caddyfile.Unmarshaler.
+//
+// Syntax:
+//
+//	knownagents <agent_types...>
+func (m *KnownagentsMatcher) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		args := d.RemainingArgs()
+		if len(args) == 0 {
+			return d.ArgErr()
+		}
+		if len(args) == 1 && args[0] == "*" {
+			m.AgentTypes = append(m.AgentTypes, allAgentTypes...)
+			continue
+		}
+		m.AgentTypes = append(m.AgentTypes, args...)
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner        = (*KnownagentsMatcher)(nil)
+	_ caddy.Validator          = (*KnownagentsMatcher)(nil)
+	_ caddyhttp.RequestMatcher = (*KnownagentsMatcher)(nil)
+	_ caddyfile.Unmarshaler    = (*KnownagentsMatcher)(nil)
+)
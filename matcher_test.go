@@ -0,0 +1,37 @@
+// SPDX-FileCopyrightText: 2024 polykernel
+// SPDX-License-Identifier: MIT or Apache-2.0
+
+package caddyknownagents
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestKnownagentsMatcherMatch(t *testing.T) {
+	fmt.Println("Testing KnownagentsMatcher.Match... ")
+
+	m := &KnownagentsMatcher{AgentTypes: []AgentType{AIDataScraper}}
+	if err := m.Provision(caddy.Context{}); err != nil {
+		t.Errorf("Provision failed with %v", err)
+		return
+	}
+
+	matching := httptest.NewRequest(http.MethodGet, "/", nil)
+	matching.Header.Set("User-Agent", "Mozilla/5.0 AppleWebKit/537.36 (KHTML, like Gecko) GPTBot/1.1")
+	if !m.Match(matching) {
+		t.Error("Expected GPTBot request to match AI Data Scraper")
+		return
+	}
+
+	notMatching := httptest.NewRequest(http.MethodGet, "/", nil)
+	notMatching.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Safari/537.36")
+	if m.Match(notMatching) {
+		t.Error("Expected ordinary browser request not to match")
+		return
+	}
+}
@@ -0,0 +1,476 @@
+// SPDX-FileCopyrightText: 2024 polykernel
+// SPDX-License-Identifier: MIT or Apache-2.0
+
+package caddyknownagents
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// Responder reacts to a request whose User-Agent has been classified as one
+// of its configured AgentTypes, e.g. by blocking, tarpitting, redirecting, or
+// serving alternate content.
+type Responder interface {
+	// Respond handles the request for the given classification. It reports
+	// whether the request was fully handled, in which case the caller must
+	// not invoke the rest of the handler chain.
+	Respond(w http.ResponseWriter, r *http.Request, classification AgentType) (handled bool, err error)
+}
+
+// classifiedResponder is implemented by every built-in responder so that
+// Provision can build the AgentType -> Responder dispatch table from
+// whatever agent_types each responder was configured with.
+type classifiedResponder interface {
+	Responder
+	configuredAgentTypes() []AgentType
+}
+
+// unmarshalAgentTypes parses an `agent_types` subdirective, supporting the
+// same `*` wildcard as robots_txt's agent_types.
+func unmarshalAgentTypes(d *caddyfile.Dispenser) ([]AgentType, error) {
+	if !d.NextArg() {
+		return nil, d.ArgErr()
+	}
+	if d.Val() == "*" {
+		if d.NextArg() {
+			return nil, d.Errf("unexpected argument '%s'", d.Val())
+		}
+		return allAgentTypes, nil
+	}
+	agentTypes := []AgentType{d.Val()}
+	for d.NextArg() {
+		agentTypes = append(agentTypes, d.Val())
+	}
+	return agentTypes, nil
+}
+
+// BlockResponder responds to classified requests with a configurable HTTP
+// status and body.
+type BlockResponder struct {
+	// The agent types this responder reacts to.
+	AgentTypes []AgentType `json:"agent_types,omitempty"`
+
+	// The HTTP status code to respond with. Defaults to 403.
+	StatusCode int `json:"status_code,omitempty"`
+
+	// The response body. Defaults to a short plain-text message.
+	Body string `json:"body,omitempty"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (BlockResponder) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.knownagents.responders.block",
+		New: func() caddy.Module { return new(BlockResponder) },
+	}
+}
+
+// Provision implements caddy.Provisioner.
+func (r *BlockResponder) Provision(_ caddy.Context) error {
+	if r.StatusCode == 0 {
+		r.StatusCode = http.StatusForbidden
+	}
+	if r.Body == "" {
+		r.Body = "Forbidden\n"
+	}
+	return nil
+}
+
+// Respond implements Responder.
+func (r BlockResponder) Respond(w http.ResponseWriter, _ *http.Request, _ AgentType) (bool, error) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(r.StatusCode)
+	_, err := io.WriteString(w, r.Body)
+	return true, err
+}
+
+func (r BlockResponder) configuredAgentTypes() []AgentType {
+	return r.AgentTypes
+}
+
+// UnmarshalCaddyfile implements caddyfile.Unmarshaler.
+//
+// Syntax:
+//
+//	block {
+//	  agent_types <types...>
+//	  status_code <code>
+//	  body        <text>
+//	}
+func (r *BlockResponder) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume responder name
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "agent_types":
+			agentTypes, err := unmarshalAgentTypes(d)
+			if err != nil {
+				return err
+			}
+			r.AgentTypes = agentTypes
+		case "status_code":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			code, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return d.Errf("parsing status_code: %v", err)
+			}
+			r.StatusCode = code
+		case "body":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			r.Body = d.Val()
+		default:
+			return d.Errf("unknown subdirective '%s'", d.Val())
+		}
+	}
+
+	return nil
+}
+
+// TarpitResponder responds to classified requests by drip-feeding bytes at a
+// configurable rate, wasting the agent's time and resources.
+type TarpitResponder struct {
+	// The agent types this responder reacts to.
+	AgentTypes []AgentType `json:"agent_types,omitempty"`
+
+	// Bytes written per second. Defaults to 1.
+	Rate int `json:"rate,omitempty"`
+
+	// How long to drip bytes before closing the connection. Defaults to 30s.
+	Duration caddy.Duration `json:"duration,omitempty"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (TarpitResponder) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.knownagents.responders.tarpit",
+		New: func() caddy.Module { return new(TarpitResponder) },
+	}
+}
+
+// Provision implements caddy.Provisioner.
+func (r *TarpitResponder) Provision(_ caddy.Context) error {
+	if r.Rate == 0 {
+		r.Rate = 1
+	}
+	if r.Duration == 0 {
+		r.Duration = caddy.Duration(30 * time.Second)
+	}
+	return nil
+}
+
+// Validate implements caddy.Validator.
+func (r TarpitResponder) Validate() error {
+	if r.Rate <= 0 {
+		return fmt.Errorf("tarpit responder rate must be positive, got %d", r.Rate)
+	}
+	if time.Second/time.Duration(r.Rate) <= 0 {
+		return fmt.Errorf("tarpit responder rate %d is too high to produce a positive interval", r.Rate)
+	}
+	return nil
+}
+
+// Respond implements Responder.
+func (r TarpitResponder) Respond(w http.ResponseWriter, req *http.Request, _ AgentType) (bool, error) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+
+	ticker := time.NewTicker(time.Second / time.Duration(r.Rate))
+	defer ticker.Stop()
+
+	deadline := time.Now().Add(time.Duration(r.Duration))
+	for time.Now().Before(deadline) {
+		select {
+		case <-req.Context().Done():
+			return true, nil
+		case <-ticker.C:
+			if _, err := w.Write([]byte{'.'}); err != nil {
+				return true, nil
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+
+	return true, nil
+}
+
+func (r TarpitResponder) configuredAgentTypes() []AgentType {
+	return r.AgentTypes
+}
+
+// UnmarshalCaddyfile implements caddyfile.Unmarshaler.
+//
+// Syntax:
+//
+//	tarpit {
+//	  agent_types <types...>
+//	  rate        <bytes_per_second>
+//	  duration    <duration>
+//	}
+func (r *TarpitResponder) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume responder name
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "agent_types":
+			agentTypes, err := unmarshalAgentTypes(d)
+			if err != nil {
+				return err
+			}
+			r.AgentTypes = agentTypes
+		case "rate":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			rate, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return d.Errf("parsing rate: %v", err)
+			}
+			r.Rate = rate
+		case "duration":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			duration, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("parsing duration: %v", err)
+			}
+			r.Duration = caddy.Duration(duration)
+		default:
+			return d.Errf("unknown subdirective '%s'", d.Val())
+		}
+	}
+
+	return nil
+}
+
+// RedirectResponder responds to classified requests with a redirect to a
+// decoy URL.
+type RedirectResponder struct {
+	// The agent types this responder reacts to.
+	AgentTypes []AgentType `json:"agent_types,omitempty"`
+
+	// The URL to redirect to.
+	To string `json:"to"`
+
+	// The redirect status code: 301 or 302. Defaults to 302.
+	StatusCode int `json:"status_code,omitempty"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (RedirectResponder) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.knownagents.responders.redirect",
+		New: func() caddy.Module { return new(RedirectResponder) },
+	}
+}
+
+// Provision implements caddy.Provisioner.
+func (r *RedirectResponder) Provision(_ caddy.Context) error {
+	if r.StatusCode == 0 {
+		r.StatusCode = http.StatusFound
+	}
+	return nil
+}
+
+// Validate implements caddy.Validator.
+func (r RedirectResponder) Validate() error {
+	if r.To == "" {
+		return fmt.Errorf("redirect responder requires a 'to' URL")
+	}
+	if r.StatusCode != http.StatusMovedPermanently && r.StatusCode != http.StatusFound {
+		return fmt.Errorf("redirect responder status_code must be 301 or 302, got %d", r.StatusCode)
+	}
+	return nil
+}
+
+// Respond implements Responder.
+func (r RedirectResponder) Respond(w http.ResponseWriter, req *http.Request, _ AgentType) (bool, error) {
+	http.Redirect(w, req, r.To, r.StatusCode)
+	return true, nil
+}
+
+func (r RedirectResponder) configuredAgentTypes() []AgentType {
+	return r.AgentTypes
+}
+
+// UnmarshalCaddyfile implements caddyfile.Unmarshaler.
+//
+// Syntax:
+//
+//	redirect {
+//	  agent_types <types...>
+//	  to          <url>
+//	  status_code <301|302>
+//	}
+func (r *RedirectResponder) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume responder name
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "agent_types":
+			agentTypes, err := unmarshalAgentTypes(d)
+			if err != nil {
+				return err
+			}
+			r.AgentTypes = agentTypes
+		case "to":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			r.To = d.Val()
+		case "status_code":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			code, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return d.Errf("parsing status_code: %v", err)
+			}
+			r.StatusCode = code
+		default:
+			return d.Errf("unknown subdirective '%s'", d.Val())
+		}
+	}
+
+	return nil
+}
+
+// AltContentResponder responds to classified requests by serving a canned
+// file instead of the real response, useful for poisoning scrapers.
+type AltContentResponder struct {
+	// The agent types this responder reacts to.
+	AgentTypes []AgentType `json:"agent_types,omitempty"`
+
+	// The file served in place of the real response.
+	File string `json:"file"`
+
+	// The Content-Type header to set. Defaults to "text/plain; charset=utf-8".
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (AltContentResponder) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.knownagents.responders.alt_content",
+		New: func() caddy.Module { return new(AltContentResponder) },
+	}
+}
+
+// Provision implements caddy.Provisioner.
+func (r *AltContentResponder) Provision(_ caddy.Context) error {
+	if r.ContentType == "" {
+		r.ContentType = "text/plain; charset=utf-8"
+	}
+	return nil
+}
+
+// Validate implements caddy.Validator.
+func (r AltContentResponder) Validate() error {
+	if r.File == "" {
+		return fmt.Errorf("alt_content responder requires a 'file'")
+	}
+	return nil
+}
+
+// Respond implements Responder.
+func (r AltContentResponder) Respond(w http.ResponseWriter, _ *http.Request, _ AgentType) (bool, error) {
+	f, err := os.Open(r.File)
+	if err != nil {
+		return true, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	w.Header().Set("Content-Type", r.ContentType)
+	w.WriteHeader(http.StatusOK)
+	_, err = io.Copy(w, f)
+	return true, err
+}
+
+func (r AltContentResponder) configuredAgentTypes() []AgentType {
+	return r.AgentTypes
+}
+
+// UnmarshalCaddyfile implements caddyfile.Unmarshaler.
+//
+// Syntax:
+//
+//	alt_content {
+//	  agent_types  <types...>
+//	  file         <path>
+//	  content_type <type>
+//	}
+func (r *AltContentResponder) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume responder name
+
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		switch d.Val() {
+		case "agent_types":
+			agentTypes, err := unmarshalAgentTypes(d)
+			if err != nil {
+				return err
+			}
+			r.AgentTypes = agentTypes
+		case "file":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			r.File = d.Val()
+		case "content_type":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			r.ContentType = d.Val()
+		default:
+			return d.Errf("unknown subdirective '%s'", d.Val())
+		}
+	}
+
+	return nil
+}
+
+func init() {
+	caddy.RegisterModule(BlockResponder{})
+	caddy.RegisterModule(TarpitResponder{})
+	caddy.RegisterModule(RedirectResponder{})
+	caddy.RegisterModule(AltContentResponder{})
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner     = (*BlockResponder)(nil)
+	_ Responder             = (*BlockResponder)(nil)
+	_ caddyfile.Unmarshaler = (*BlockResponder)(nil)
+
+	_ caddy.Provisioner     = (*TarpitResponder)(nil)
+	_ caddy.Validator       = (*TarpitResponder)(nil)
+	_ Responder             = (*TarpitResponder)(nil)
+	_ caddyfile.Unmarshaler = (*TarpitResponder)(nil)
+
+	_ caddy.Provisioner     = (*RedirectResponder)(nil)
+	_ caddy.Validator       = (*RedirectResponder)(nil)
+	_ Responder             = (*RedirectResponder)(nil)
+	_ caddyfile.Unmarshaler = (*RedirectResponder)(nil)
+
+	_ caddy.Provisioner     = (*AltContentResponder)(nil)
+	_ caddy.Validator       = (*AltContentResponder)(nil)
+	_ Responder             = (*AltContentResponder)(nil)
+	_ caddyfile.Unmarshaler = (*AltContentResponder)(nil)
+)